@@ -10,12 +10,20 @@
 // Most of the comments are taken from the Java version.
 //
 // There are two representations of puzzles that we will use:
-// 1. A gridstring is 81 chars, with characters '0' or '.' for blank and '1' to '9' for digits.
-// 2. A puzzle grid is an int[81] with a digit d (1-9) represented by the integer (1 << (d - 1));
-//    that is, a bit pattern that has a single 1 bit representing the digit.
-//    A blank is represented by the OR of all the digits 1-9, meaning that any digit is possible.
-//    While solving the puzzle, some of these digits are eliminated, leaving fewer possibilities.
-//    The puzzle is solved when every square has only a single possibility.
+// 1. A gridstring is N*N chars, with characters '0' or '.' for blank, '1' to '9'
+//    for digits 1-9, and 'A'.. (or 'a'..) for digits above 9, as found in .sdk files
+//    for 16x16 and 25x25 puzzles.
+// 2. A puzzle grid is a []uint64 with a digit d (1-N) represented by the integer
+//    (1 << (d - 1)); that is, a bit pattern that has a single 1 bit representing
+//    the digit. A blank is represented by the OR of all the digits 1-N, meaning
+//    that any digit is possible. While solving the puzzle, some of these digits
+//    are eliminated, leaving fewer possibilities. The puzzle is solved when every
+//    square has only a single possibility.
+//
+// The grid no longer needs to be 9x9: a Board describes any square grid whose
+// side N = BW*BH can be tiled by BW x BH blocks (4x4, 6x6, 9x9, 16x16, 25x25, ...).
+// A single uint64 bitset per cell is enough for every such board up to 64x64, so
+// there is no need to spill the bitset across several words.
 //
 // Search for a solution with `search`:
 //  - Fill an empty square with a guessed digit and do constraint propagation.
@@ -24,7 +32,7 @@
 //  - In selecting an empty square, we pick one that has the minimum number of possible digits.
 //  - To be able to back up, we need to keep the grid from the previous recursive level.
 //    But we only need to keep one grid for each level, so to save garbage collection,
-//    we pre-allocate one grid per level (there are 81 levels) in a `gridpool`.
+//    we pre-allocate one grid per level (one per cell) in a `gridpool`.
 // Do constraint propagation with `arcConsistent`, `dualConsistent`.
 //
 
@@ -35,16 +43,22 @@ import (
 	"errors"
 	"fmt"
 	"math/bits"
+	"math/rand"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 //////////////////////////////// main; command line options //////////////////////////////
 
 const usage = "" +
-	"usage: Sudoku -(no)[fghnprstuv] | -[RT]<number> | <filename> ...\n" +
+	"usage: Sudoku -(no)[fghnprstuv] | -[R]<number> | -N<rows>x<cols> | -A<algorithm> | -count=<n>\n" +
+	"              | -gen <level> [-count <n>] [-symmetric] | -j<number>\n" +
+	"              | -K <kenkenfile> | -B <starbattlefile> | <filename> ...\n" +
 	"E.g., -v turns verify flag on, -nov turns it off. -R requires a number. The options:\n\n" +
 	"  -f(ile)    Print summary stats for each file (default on)\n" +
 	"  -g(rid)    Print each puzzle grid and solution grid (default off)\n" +
@@ -54,6 +68,21 @@ const usage = "" +
 	"  -s(earch)  Run search (default on, but some puzzles can be solved with CSP methods alone)\n" +
 	"  -v(erify)  Verify each solution is valid (default on)\n" +
 	"  -R<number> Repeat each puzzle <number> times (default 1)\n" +
+	"  -N<rows>x<cols> Block size of the grid, e.g. -N3x3 for 9x9 (default), -N2x2 for 4x4,\n" +
+	"                  -N4x4 for 16x16, -N5x5 for 25x25\n" +
+	"  -A<algorithm> Solver backend: csp (default), dlx (Algorithm X / dancing links), or\n" +
+	"                auto (time a sample puzzle with both and use the faster one)\n" +
+	"  -count=<n> With -Adlx, stop after finding <n> solutions instead of one (for\n" +
+	"             uniqueness checking); 0 (default) finds a single solution\n" +
+	"  -gen <level>   Generate puzzles with a unique solution instead of solving any;\n" +
+	"                 <level> is easy, medium, hard or evil\n" +
+	"  -count <n>     With -gen, how many puzzles to emit (default 1)\n" +
+	"  -symmetric     With -gen, remove cells in 180-degree rotational pairs\n" +
+	"  -P<level>  Constraint propagation in eliminate: 0 naked/hidden single only (default),\n" +
+	"             1 also naked/hidden pairs and triples, 2 also X-wing\n" +
+	"  -j<number> Number of puzzles to solve concurrently in a file (default: number of CPUs)\n" +
+	"  -K <file>  Solve the KenKen puzzle(s) in file instead of a Sudoku\n" +
+	"  -B <file>  Solve the Star Battle puzzle(s) in file instead of a Sudoku\n" +
 	"  <filename> Solve all puzzles in filename, which has one puzzle per line"
 
 //////////////////////////////// Globals ////////////////////////////////
@@ -67,94 +96,186 @@ var runSearch = true         // -s
 var verifySolution = true    // -v
 var repeat = 1               // -R
 
-var backtracks = 0 // count total backtracks
+var backtracks int64 = 0 // count total backtracks; atomic since workers share it
+
+var algorithm = "csp" // -A, selects the Solver: "csp", "dlx" or "auto"
+var countLimit = 0    // -count=<n>, with -Adlx stop after this many solutions (0 = just one)
+
+var genLevel = ""        // -gen <level>, turns on generator mode instead of solving
+var genCount = 1         // -count <n> with -gen: how many puzzles to emit
+var genSymmetric = false // -symmetric, with -gen
+
+var propagationLevel = 0 // -P, how much constraint propagation eliminate does beyond the basics
+
+var numWorkers = runtime.NumCPU() // -j, number of puzzles solved concurrently in solveList
+
+//////////////////////////////// Board ////////////////////////////////
+
+// Board describes the geometry of a square NxN Sudoku grid tiled with BW x BH
+// blocks (BW columns, BH rows per block; N == BW*BH). The classic 9x9 puzzle
+// is a 3x3 board. All of the lookup tables that used to be fixed-size [9*9]
+// globals now live on the Board, sized for whatever N was requested.
+type Board struct {
+	N  int // number of cells on a side of the grid
+	BW int // block width (columns per block)
+	BH int // block height (rows per block)
+
+	AllDigits uint64   // bitmask with the low N bits set
+	Digits    []uint64 // Digits[d-1] == 1<<(d-1), for d in 1..N
 
-//////////////////////////////// Constants ////////////////////////////////
+	Squares  []int      // 0 .. N*N-1
+	AllUnits [][]int    // every row, column and block unit, 3*N of them
+	Units    [][3][]int // Units[s] holds the row, column and block containing square s
+	Peers    [][]int    // Peers[s] holds the other squares sharing a unit with s
+	Box      []int      // Box[s] is the 0..N-1 index of the block containing square s
+}
 
-const N = 9 // Number of cells on a side of grid.
-const ALL_DIGITS = 0b111111111
+// NewBoard builds a Board for a grid of blockHeight x blockWidth blocks,
+// e.g. NewBoard(3, 3) is the classic 9x9 Sudoku, NewBoard(2, 2) is 4x4,
+// NewBoard(4, 4) is 16x16 and NewBoard(5, 5) is 25x25.
+func NewBoard(blockHeight, blockWidth int) *Board {
+	n := blockHeight * blockWidth
+	b := &Board{N: n, BW: blockWidth, BH: blockHeight}
 
-var DIGITS = [...]int{1 << 0, 1 << 1, 1 << 2, 1 << 3, 1 << 4, 1 << 5, 1 << 6, 1 << 7, 1 << 8}
-var ROWS = []int{0, 1, 2, 3, 4, 5, 6, 7, 8}
-var COLS = ROWS
-var SQUARES [N * N]int
-var BLOCKS = [][]int{{0, 1, 2}, {3, 4, 5}, {6, 7, 8}}
-var ALL_UNITS [3 * N][]int
-var UNITS [N * N][3][N]int
-var PEERS [N * N][20]int
-var NUM_DIGITS [ALL_DIGITS + 1]int
-var HIGHEST_DIGIT [ALL_DIGITS + 1]int
+	b.AllDigits = uint64(1)<<uint(n) - 1
+	b.Digits = make([]uint64, n)
+	for i := 0; i < n; i++ {
+		b.Digits[i] = uint64(1) << uint(i)
+	}
 
-// init do initialization of other 'constant' global variables
-func init() {
-	// Initialize SQUARES to be the numbers from 0 to N*N
-	for i := range N * N {
-		SQUARES[i] = i
+	b.Squares = make([]int, n*n)
+	for i := range b.Squares {
+		b.Squares[i] = i
 	}
 
-	// Initialize ALL_UNITS to be an array of the 27 units: rows, columns, and blocks
-	i := 0
-	for _, r := range ROWS {
-		ALL_UNITS[i] = cross([]int{r}, COLS)
-		i++
+	rows := make([]int, n)
+	cols := make([]int, n)
+	for i := 0; i < n; i++ {
+		rows[i] = i
+		cols[i] = i
+	}
+	blockRows := groupBy(rows, blockHeight)
+	blockCols := groupBy(cols, blockWidth)
+
+	// allUnits is the list of all N+N+N/(BW*BH) units: rows, columns, and blocks
+	allUnits := make([][]int, 0, 3*n)
+	for _, r := range rows {
+		allUnits = append(allUnits, cross(n, []int{r}, cols))
 	}
-	for _, c := range COLS {
-		ALL_UNITS[i] = cross(ROWS, []int{c})
-		i++
+	for _, c := range cols {
+		allUnits = append(allUnits, cross(n, rows, []int{c}))
 	}
-	for _, rb := range BLOCKS {
-		for _, cb := range BLOCKS {
-			ALL_UNITS[i] = cross(rb, cb)
-			i++
+	for _, rb := range blockRows {
+		for _, cb := range blockCols {
+			allUnits = append(allUnits, cross(n, rb, cb))
+		}
+	}
+	b.AllUnits = allUnits
+
+	// The last n entries of allUnits are the blocks, in order; record which
+	// block each square belongs to so the DLX encoding can number them.
+	b.Box = make([]int, n*n)
+	for bi, u := range allUnits[2*n:] {
+		for _, s := range u {
+			b.Box[s] = bi
 		}
 	}
-	// debug fmt.Println(ALL_UNITS)
 
-	// Initialize each UNITS[s] to be an array of the 3 units for square s.
-	for _, s := range SQUARES {
-		i = 0
-		for _, u := range ALL_UNITS {
-			if member(s, u) {
-				UNITS[s][i] = [9]int(u)
+	// Each Units[s] is the 3 units (row, column, block) containing square s.
+	b.Units = make([][3][]int, n*n)
+	for _, s := range b.Squares {
+		i := 0
+		for _, u := range allUnits {
+			if memberInt(s, u) {
+				b.Units[s][i] = u
 				i++
 			}
 		}
 	}
 
-	// Initialize each PEERS[s] to be an array of the 20 squares that are peers of square s.
-	for _, s := range SQUARES {
-		i = 0
-		for _, u := range UNITS[s] {
+	// Each Peers[s] is the squares that share a unit with s, s itself excluded.
+	b.Peers = make([][]int, n*n)
+	for _, s := range b.Squares {
+		peers := make([]int, 0, 3*(n-1))
+		for _, u := range b.Units[s] {
 			for _, s2 := range u {
-				if s2 != s && !member(s2, PEERS[s][:i]) {
-					PEERS[s][i] = s2
-					i++
+				if s2 != s && !memberInt(s2, peers) {
+					peers = append(peers, s2)
 				}
 			}
 		}
+		b.Peers[s] = peers
 	}
-
-	// Initialize NUM_DIGITS[val] to be the number of 1 bits in the bitset val
-	// and HIGHEST_DIGIT[val] to the highest bit set in the bitset val
-	for val := 0; val <= ALL_DIGITS; val++ {
-		uval := uint(val)
-		NUM_DIGITS[val] = bits.OnesCount(uval)
-		HIGHEST_DIGIT[val] = bits.Len(uval)
-	}
+	return b
 }
 
+// board is the board the command line options and input files are solved against.
+// It defaults to the classic 9x9 grid; -N<rows>x<cols> replaces it before any
+// file is read.
+var board = NewBoard(3, 3)
+
 //////////////////////////////// Main ////////////////////////////////
 
-// main parse command line args and solve puzzles in files.
+// main parse command line args and solve puzzles in files, or generate them with -gen.
+// -gen and -count <n> take their value from the following argument rather than being
+// self-contained like the single-letter options, so the options are scanned by index.
 func main() {
-	for _, arg := range os.Args[1:] {
+	args := os.Args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
 		argrs := []rune(arg)
-		if argrs[0] != '-' {
-			err := solveFile(arg)
+		switch {
+		case argrs[0] != '-':
+			if err := board.solveFile(arg); err != nil {
+				fmt.Println(err)
+			}
+		case arg == "-gen":
+			i++
+			if i >= len(args) {
+				fmt.Println("-gen needs a difficulty level\n" + usage)
+				break
+			}
+			genLevel = strings.ToLower(args[i])
+		case arg == "-count":
+			i++
+			if i >= len(args) {
+				fmt.Println("-count needs a number\n" + usage)
+				break
+			}
+			n, err := strconv.Atoi(args[i])
 			if err != nil {
+				fmt.Println("No numeric value: -count " + args[i] + "\n" + usage)
+			} else {
+				genCount = n
+			}
+		case arg == "-symmetric":
+			genSymmetric = true
+		case arg == "-K":
+			i++
+			if i >= len(args) {
+				fmt.Println("-K needs a KenKen puzzle filename\n" + usage)
+				break
+			}
+			if err := solveKenKenFile(args[i]); err != nil {
 				fmt.Println(err)
 			}
-		} else {
+		case arg == "-B":
+			i++
+			if i >= len(args) {
+				fmt.Println("-B needs a Star Battle puzzle filename\n" + usage)
+				break
+			}
+			if err := solveStarBattleFile(args[i]); err != nil {
+				fmt.Println(err)
+			}
+		case strings.HasPrefix(arg, "-count="):
+			n, err := strconv.Atoi(arg[len("-count="):])
+			if err != nil {
+				fmt.Println("No numeric value: " + arg + "\n" + usage)
+			} else {
+				countLimit = n
+			}
+		default:
 			option := argrs[1]
 			value := true
 			if strings.HasPrefix(arg, "-no") {
@@ -180,70 +301,234 @@ func main() {
 				verifySolution = value
 			case 'R':
 				repeat, err = strconv.Atoi(arg[2:])
+			case 'N':
+				err = setBoardSize(arg[2:])
+			case 'A':
+				err = setAlgorithm(arg[2:])
+			case 'P':
+				propagationLevel, err = strconv.Atoi(arg[2:])
+			case 'j':
+				numWorkers, err = strconv.Atoi(arg[2:])
 			default:
 				fmt.Println("Unrecognized option: " + arg + "\n" + usage)
 			}
 			if err != nil {
-				fmt.Println("No numeric value: " + arg + "\n" + usage)
+				fmt.Println(err.Error() + "\n" + usage)
 			}
 		}
 	}
+	if genLevel != "" {
+		if err := board.generate(genLevel, genCount, genSymmetric); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// setBoardSize parses a "<rows>x<cols>" block size (e.g. "3x3", "4x4") and
+// replaces the global board with one built for those block dimensions.
+func setBoardSize(spec string) error {
+	parts := strings.Split(strings.ToLower(spec), "x")
+	if len(parts) != 2 {
+		return errors.New("No block size (want <rows>x<cols>): -N" + spec)
+	}
+	blockHeight, err1 := strconv.Atoi(parts[0])
+	blockWidth, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || blockHeight < 1 || blockWidth < 1 {
+		return errors.New("Bad block size: -N" + spec)
+	}
+	board = NewBoard(blockHeight, blockWidth)
+	return nil
+}
+
+// setAlgorithm selects the Solver backend used to solve puzzles: "csp" for
+// the original search/constraint-propagation engine, "dlx" for Algorithm X
+// via dancing links, or "auto" to time a sample puzzle with both and keep
+// the faster one.
+func setAlgorithm(name string) error {
+	name = strings.ToLower(name)
+	switch name {
+	case "csp", "dlx", "auto":
+		algorithm = name
+		return nil
+	default:
+		return errors.New("Unknown algorithm (want csp, dlx or auto): -A" + name)
+	}
 }
 
 //////////////////////////////// Handling Lists of Puzzles ////////////////////////////////
 
 // solveFile  Solve all the puzzles in a file. Report timing statistics.
-func solveFile(filename string) (err error) {
-	grids, err := readFile(filename)
+func (b *Board) solveFile(filename string) (err error) {
+	grids, err := b.readFile(filename)
 	// debug fmt.Println("solveFile grids", grids)
 	if err != nil {
 		return err
 	}
+	var sample []uint64
+	if len(grids) > 0 {
+		sample = grids[0]
+	}
+	algo := b.resolveAlgorithm(sample)
 	startFileTime := time.Now()
-	solveList(grids)
+	b.solveList(grids, algo)
 	if printFileStats {
 		printStats(len(grids)*repeat, startFileTime, filename)
 	}
 	return nil
 }
 
-// solveList solve a list of puzzles in a single thread.
+// puzzleJob is one puzzle waiting to be solved by solveList's worker pool.
+type puzzleJob struct {
+	idx  int
+	grid []uint64
+}
+
+// puzzleResult is a solved puzzleJob's outcome, carrying enough to print
+// its stats and grid exactly as a single-threaded solveList would.
+type puzzleResult struct {
+	idx        int
+	grid       []uint64
+	solution   []uint64
+	timings    []time.Duration // one entry per -R repeat, only filled if -p
+	backtracks []int64         // one entry per -R repeat, parallel to timings
+}
+
+// backtracksCounter is implemented by Solvers that can report how many
+// backtracks their most recent Solve call took. CSPSolver is the only one
+// that backtracks at all; DLXSolver doesn't implement it, and is treated
+// as always reporting 0.
+type backtracksCounter interface {
+	Backtracks() int64
+}
+
+// solveList solves a list of puzzles using a -j-sized pool of workers,
+// each with its own Solver (and so its own gridpool) of the kind named by
+// algo; -count=<n> enumeration instead runs single-threaded, since it's a
+// diagnostic rather than a batch-solving workload.
 // repeat -R<number> times; print each puzzle's stats if -p; print grid if -g; verify if -v.
-func solveList(grids [][]int) {
-	puzzle := make([]int, N*N)          // Used to save a copy of the original grid
-	gridpool := make([][N * N]int, N*N) // Reuse grids during the search
-	for g, grid := range grids {
-		copy(puzzle, grid)
-		for i := 0; i < repeat; i++ {
-			var startTime time.Time
-			if printPuzzleStats {
-				startTime = time.Now()
+func (b *Board) solveList(grids [][]uint64, algo string) {
+	if countLimit > 0 {
+		b.solveListCounting(grids, algo)
+		return
+	}
+
+	jobs := make(chan puzzleJob, len(grids))
+	results := make(chan puzzleResult, len(grids))
+
+	workers := numWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			solver := b.newSolverForAlgo(algo)
+			counter, countsBacktracks := solver.(backtracksCounter)
+			for job := range jobs {
+				var solution []uint64
+				var timings []time.Duration
+				var btCounts []int64
+				if printPuzzleStats {
+					timings = make([]time.Duration, repeat)
+					btCounts = make([]int64, repeat)
+				}
+				for i := 0; i < repeat; i++ {
+					start := time.Now()
+					s := solver.Solve(job.grid) // All the real work is in this line.
+					if i == 0 {
+						// s may alias a CSPSolver's gridpool (see search, which
+						// returns gridpool[level]), which the next repeat or job
+						// will overwrite; copy it so it survives to the result
+						// reorder buffer below.
+						if s != nil {
+							solution = make([]uint64, len(s))
+							copy(solution, s)
+						}
+					}
+					// Solve just reset and refilled the solver's own counter
+					// (see CSPSolver.Solve), so this is this repeat's count
+					// alone; fold it into the shared global so file-level
+					// summary stats still see the true total, then hand the
+					// same count back in this result for an accurate
+					// per-puzzle -p line, instead of -p reading the shared
+					// global back out (which other workers keep changing).
+					var bt int64
+					if countsBacktracks {
+						bt = counter.Backtracks()
+						atomic.AddInt64(&backtracks, bt)
+					}
+					if printPuzzleStats {
+						timings[i] = time.Since(start)
+						btCounts[i] = bt
+					}
+				}
+				results <- puzzleResult{idx: job.idx, grid: job.grid, solution: solution, timings: timings, backtracks: btCounts}
 			}
-			solution := initialize(grid) // All the real work is a on these lines.
-			// debug fmt.Println("solveList", solution)
-			if runSearch {
-				solution = search(solution, gridpool, 0)
+		}()
+	}
+
+	for idx, grid := range grids {
+		jobs <- puzzleJob{idx: idx, grid: grid}
+	}
+	close(jobs)
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Workers finish in whatever order they finish; buffer results here and
+	// only print once every earlier puzzle has been printed, so -p/-g output
+	// stays in input order regardless of worker scheduling.
+	pending := make(map[int]puzzleResult, workers)
+	next := 0
+	puzzle := make([]uint64, b.N*b.N)
+	for res := range results {
+		pending[res.idx] = res
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
 			}
-			puzzleNo := "Puzzle " + strconv.Itoa(g+1)
-			if printPuzzleStats {
-				printStats(1, startTime, puzzleNo)
+			delete(pending, next)
+			puzzleNo := "Puzzle " + strconv.Itoa(r.idx+1)
+			for i, elapsed := range r.timings {
+				printBacktracks(1, elapsed, r.backtracks[i], puzzleNo)
 			}
-			if i == 0 && (printGrid || (verifySolution && !verify(solution, puzzle))) {
-				printGrids(puzzleNo, grid, solution)
+			copy(puzzle, r.grid)
+			if printGrid || (verifySolution && !b.verify(r.solution, puzzle)) {
+				b.printGrids(puzzleNo, r.grid, r.solution)
 			}
+			next++
 		}
 	}
 }
 
+// solveListCounting runs -count=<n> solution enumeration with -Adlx,
+// single-threaded: it's a uniqueness check, not a batch workload, so the
+// worker pool in solveList doesn't apply here.
+func (b *Board) solveListCounting(grids [][]uint64, algo string) {
+	dlx, ok := b.newSolverForAlgo(algo).(*DLXSolver)
+	if !ok {
+		dlx = NewDLXSolver(b)
+	}
+	for g, grid := range grids {
+		puzzleNo := "Puzzle " + strconv.Itoa(g+1)
+		solutions := dlx.SolveN(grid, countLimit)
+		fmt.Printf("%s: %d solution(s) found (limit %d)\n", puzzleNo, len(solutions), countLimit)
+	}
+}
+
 //////////////////////////////// Utility functions ////////////////////////////////
 
-// cross Return an array of all squares in the intersection of these rows and cols
-func cross(rows, cols []int) []int {
+// cross Return an array of all squares (in an N-wide grid) in the intersection of these rows and cols
+func cross(n int, rows, cols []int) []int {
 	result := make([]int, len(rows)*len(cols))
 	i := 0
 	for _, r := range rows {
 		for _, c := range cols {
-			result[i] = N*r + c
+			result[i] = n*r + c
 			i++
 		}
 	}
@@ -251,8 +536,18 @@ func cross(rows, cols []int) []int {
 	return result
 }
 
-// member return true iff item is an element of array.
-func member(item int, array []int) bool {
+// groupBy splits xs into consecutive chunks of the given size.
+// Used to turn a board's rows/cols into the row/col bands that make up its blocks.
+func groupBy(xs []int, size int) [][]int {
+	groups := make([][]int, 0, len(xs)/size)
+	for i := 0; i < len(xs); i += size {
+		groups = append(groups, xs[i:i+size])
+	}
+	return groups
+}
+
+// memberInt return true iff item is an element of array.
+func memberInt(item int, array []int) bool {
 	// debug fmt.Println("member", item, array)
 	for i := 0; i < len(array); i++ {
 		if array[i] == item {
@@ -280,48 +575,61 @@ func reverse(s string) string {
 // search for a solution to grid. If there is an unfilled square, select one
 // and try--that is, search recursively--every possible digit for the square.
 // return false if no solution found.
-func search(grid []int, gridpool [][N * N]int, level int) []int {
+// Naked/hidden subsets and X-wing, if -P asked for them, run once per
+// search node here rather than inside eliminate/fill: eliminate runs once
+// per peer per fill, and fill itself recurses further via arcConsistent's
+// and dualConsistent's own forced fills, so scanning at either of those
+// points made those O(N) (subsets) or O(N^2) (X-wing) passes dominate the
+// actual search cost. Running them once per search node instead keeps
+// their cost proportional to the number of branches search actually
+// tries, not the much larger number of eliminations/cascaded fills within
+// a single branch.
+// backtracks counts into *backtracks rather than the shared global, since
+// each CSPSolver (and so each solveList worker) owns its own counter; it's
+// a plain pointer, not atomic, because only the one goroutine that owns
+// this CSPSolver ever touches it.
+func (b *Board) search(grid []uint64, gridpool [][]uint64, level int, backtracks *int64) []uint64 {
 	if grid == nil {
 		return nil
 	}
-	s := selectSquare(grid)
+	s := b.selectSquare(grid)
 	if s == -1 {
 		return grid // No squares to select means we are done!
 	}
-	for _, d := range DIGITS {
+	for _, d := range b.Digits {
 		// For each possible digit d that could fill square s, try it
 		if (d & grid[s]) > 0 {
 			// Copy grid's contents into gridpool[level], and use that at the next level
-			copy(gridpool[level][:], grid)
-			result := search(fill(gridpool[level][:], s, d), gridpool, level+1)
+			copy(gridpool[level], grid)
+			result := b.search(b.higherPropagate(b.fill(gridpool[level], s, d)), gridpool, level+1, backtracks)
 			if result != nil {
 				return result
 			}
-			backtracks += 1
+			*backtracks++
 		}
 	}
 	return nil
 }
 
 // verify that grid is a solution to the puzzle.
-func verify(grid []int, puzzle []int) bool {
+func (b *Board) verify(grid []uint64, puzzle []uint64) bool {
 	if grid == nil {
 		return false
 	}
 	// Check that all squares have a single digit, and
 	// no filled square in the puzzle was changed in the solution.
-	for _, s := range SQUARES {
-		if (NUM_DIGITS[grid[s]] != 1) || (NUM_DIGITS[puzzle[s]] == 1 && grid[s] != puzzle[s]) {
+	for _, s := range b.Squares {
+		if (bits.OnesCount64(grid[s]) != 1) || (bits.OnesCount64(puzzle[s]) == 1 && grid[s] != puzzle[s]) {
 			return false
 		}
 	}
 	// Check that each unit is a permutation of digits
-	for _, u := range ALL_UNITS {
-		unit_digits := 0 // All the digits in a unit.
-		for s := range u {
-			unit_digits |= grid[s]
+	for _, u := range b.AllUnits {
+		unitDigits := uint64(0) // All the digits in a unit.
+		for _, s := range u {
+			unitDigits |= grid[s]
 		}
-		if unit_digits != ALL_DIGITS {
+		if unitDigits != b.AllDigits {
 			return false
 		}
 	}
@@ -330,11 +638,11 @@ func verify(grid []int, puzzle []int) bool {
 
 // selectSquare choose an unfilled square with the minimum number of possible values.
 // If all squares are filled, return -1 (which means the puzzle is complete).
-func selectSquare(grid []int) int {
+func (b *Board) selectSquare(grid []uint64) int {
 	square := -1
-	mint := N + 1
-	for _, s := range SQUARES {
-		c := NUM_DIGITS[grid[s]]
+	mint := b.N + 1
+	for _, s := range b.Squares {
+		c := bits.OnesCount64(grid[s])
 		if c == 2 {
 			return s // Can't get fewer than 2 possible digits
 		} else if c > 1 && c < mint {
@@ -347,14 +655,14 @@ func selectSquare(grid []int) int {
 
 // fill grid[s] = d. If this leads to contradiction, return nil.
 // grid is a slice, gots modified.
-func fill(grid []int, s, d int) []int {
+func (b *Board) fill(grid []uint64, s int, d uint64) []uint64 {
 	if grid == nil || grid[s]&d == 0 {
 		grid = nil
 		return nil // d not possible for grid[s]
 	}
 	grid[s] = d
-	for _, p := range PEERS[s] {
-		if !eliminate(grid, p, d) {
+	for _, p := range b.Peers[s] {
+		if !b.eliminate(grid, p, d) {
 			grid = nil
 			return nil // If we can't eliminate d from all peers of s, then fail
 		}
@@ -363,36 +671,58 @@ func fill(grid []int, s, d int) []int {
 }
 
 // Eliminate digit d as a possibility for grid[s].
-// Run the 3 constraint propagation routines.
+// Run the 3 constraint propagation routines (arc and dual consistency).
 // If constraint propagation detects a contradiction, return false.
 // Attention: elements of grid are modified, size stays the same.
-func eliminate(grid []int, s, d int) bool {
+func (b *Board) eliminate(grid []uint64, s int, d uint64) bool {
 	// debug fmt.Println(">eliminate", grid, s, d)
 	if grid[s]&d == 0 {
 		return true // d already eliminated from grid[s]
 	}
 	grid[s] -= d
 	// debug fmt.Println(" eliminate", grid, s, d)
-	ret := arcConsistent(grid, s) && dualConsistent(grid, s, d)
+	if !(b.arcConsistent(grid, s) && b.dualConsistent(grid, s, d)) {
+		return false
+	}
 	// debug fmt.Println("<eliminate", grid, s, d)
-	return ret
+	return true
+}
+
+// higherPropagate runs naked/hidden subsets and X-wing against grid, if -P
+// asked for them, once as a single extra consistency pass layered on top
+// of fill's arc/dual-consistency propagation. Returns nil (contradiction)
+// if any of them fail, same as fill; returns grid unchanged if -P0 (or if
+// grid is already nil, e.g. because fill itself already failed).
+func (b *Board) higherPropagate(grid []uint64) []uint64 {
+	if grid == nil || propagationLevel < 1 {
+		return grid
+	}
+	for _, u := range b.AllUnits {
+		if !b.nakedSubsets(grid, u) || !b.hiddenSubsets(grid, u) {
+			return nil
+		}
+	}
+	if propagationLevel >= 2 && !b.xWing(grid) {
+		return nil
+	}
+	return grid
 }
 
 //////////////////////////////// Constraint Propagation ////////////////////////////////
 
 // arcConsistent check if square s is consistent: that is, it has multiple possible values,
 // or it has one possible value which we can consistently fill.
-func arcConsistent(grid []int, s int) bool {
+func (b *Board) arcConsistent(grid []uint64, s int) bool {
 	// debug fmt.Println("arcConsistent", grid, s, grid[s])
-	count := NUM_DIGITS[grid[s]]
-	return count >= 2 || (count == 1 && (fill(grid, s, grid[s]) != nil))
+	count := bits.OnesCount64(grid[s])
+	return count >= 2 || (count == 1 && (b.fill(grid, s, grid[s]) != nil))
 }
 
 // dualConsistent after we eliminate d from possibilities for grid[s],
 // check each unit of s and make sure there is some position in the unit where d can go.
 // If there is only one possible place for d, fill it with d.
-func dualConsistent(grid []int, s, d int) bool {
-	for _, u := range UNITS[s] {
+func (b *Board) dualConsistent(grid []uint64, s int, d uint64) bool {
+	for _, u := range b.Units[s] {
 		dPlaces := 0 // The number of possible places for d within unit u
 		dplace := -1 // Try to find a place in the unit where d can go
 		for _, s2 := range u {
@@ -404,38 +734,647 @@ func dualConsistent(grid []int, s, d int) bool {
 				dplace = s2
 			}
 		}
-		if dPlaces == 0 || (dPlaces == 1 && (fill(grid, dplace, d) == nil)) {
+		if dPlaces == 0 || (dPlaces == 1 && (b.fill(grid, dplace, d) == nil)) {
+			return false
+		}
+	}
+	return true
+}
+
+// nakedSubsets finds naked pairs and triples in unit: if k (2 or 3) cells
+// between them have only k candidates left, no other cell in the unit can
+// hold any of those k digits. Gated by -P1.
+func (b *Board) nakedSubsets(grid []uint64, unit []int) bool {
+	n := len(unit)
+	for i := 0; i < n; i++ {
+		mi := grid[unit[i]]
+		ci := bits.OnesCount64(mi)
+		if ci < 2 || ci > 3 {
+			continue
+		}
+		for j := i + 1; j < n; j++ {
+			cj := bits.OnesCount64(grid[unit[j]])
+			if cj < 2 || cj > 3 {
+				continue
+			}
+			pairMask := mi | grid[unit[j]]
+			if bits.OnesCount64(pairMask) == 2 {
+				if !b.eliminateMaskFromOthers(grid, unit, []int{unit[i], unit[j]}, pairMask) {
+					return false
+				}
+			}
+			for l := j + 1; l < n; l++ {
+				cl := bits.OnesCount64(grid[unit[l]])
+				if cl < 2 || cl > 3 {
+					continue
+				}
+				tripleMask := pairMask | grid[unit[l]]
+				if bits.OnesCount64(tripleMask) == 3 {
+					if !b.eliminateMaskFromOthers(grid, unit, []int{unit[i], unit[j], unit[l]}, tripleMask) {
+						return false
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+// eliminateMaskFromOthers removes every digit in mask from every square of
+// unit other than those in subset.
+func (b *Board) eliminateMaskFromOthers(grid []uint64, unit []int, subset []int, mask uint64) bool {
+	for _, s2 := range unit {
+		if memberInt(s2, subset) {
+			continue
+		}
+		for _, d := range b.Digits {
+			if mask&d != 0 && grid[s2]&d != 0 {
+				if !b.eliminate(grid, s2, d) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// hiddenSubsets finds hidden pairs and triples in unit: if k (2 or 3)
+// digits between them only fit in the same k cells of the unit, every
+// other candidate can be removed from those k cells. Gated by -P1.
+func (b *Board) hiddenSubsets(grid []uint64, unit []int) bool {
+	numDigits := len(b.Digits)
+	cellsFor := make([][]int, numDigits)
+	for di, d := range b.Digits {
+		for _, s2 := range unit {
+			if grid[s2]&d != 0 {
+				cellsFor[di] = append(cellsFor[di], s2)
+			}
+		}
+	}
+	for i := 0; i < numDigits; i++ {
+		if len(cellsFor[i]) < 1 || len(cellsFor[i]) > 3 {
+			continue
+		}
+		for j := i + 1; j < numDigits; j++ {
+			if len(cellsFor[j]) < 1 || len(cellsFor[j]) > 3 {
+				continue
+			}
+			pairCells := unionInts(cellsFor[i], cellsFor[j])
+			if len(pairCells) == 2 {
+				if !b.restrictCellsToMask(grid, pairCells, b.Digits[i]|b.Digits[j]) {
+					return false
+				}
+			}
+			for l := j + 1; l < numDigits; l++ {
+				if len(cellsFor[l]) < 1 || len(cellsFor[l]) > 3 {
+					continue
+				}
+				tripleCells := unionInts(pairCells, cellsFor[l])
+				if len(tripleCells) == 3 {
+					if !b.restrictCellsToMask(grid, tripleCells, b.Digits[i]|b.Digits[j]|b.Digits[l]) {
+						return false
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+// unionInts returns the deduplicated union of a and b.
+func unionInts(a, b []int) []int {
+	result := append([]int{}, a...)
+	for _, x := range b {
+		if !memberInt(x, result) {
+			result = append(result, x)
+		}
+	}
+	return result
+}
+
+// restrictCellsToMask removes every candidate not in mask from each of cells.
+func (b *Board) restrictCellsToMask(grid []uint64, cells []int, mask uint64) bool {
+	for _, s2 := range cells {
+		extra := grid[s2] &^ mask
+		for _, d := range b.Digits {
+			if extra&d != 0 {
+				if !b.eliminate(grid, s2, d) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// xWing looks for the X-wing pattern over the whole board: for some digit
+// d, if two rows each have d possible in exactly the same two columns, d
+// can be eliminated from those columns in every other row (and the
+// symmetric case, two columns sharing the same two rows). Gated by -P2.
+func (b *Board) xWing(grid []uint64) bool {
+	n := b.N
+	rows := b.AllUnits[0:n]
+	cols := b.AllUnits[n : 2*n]
+	for _, d := range b.Digits {
+		if !b.xWingLines(grid, rows, d, func(s int) int { return s % n }) {
 			return false
 		}
+		if !b.xWingLines(grid, cols, d, func(s int) int { return s / n }) {
+			return false
+		}
+	}
+	return true
+}
+
+// xWingLines implements xWing for one orientation: lines is either the
+// rows or the columns of the board, and crossIndex maps a square to its
+// index along the other axis (column for rows, row for columns).
+func (b *Board) xWingLines(grid []uint64, lines [][]int, d uint64, crossIndex func(s int) int) bool {
+	n := len(lines)
+	crossMask := make([]uint64, n)
+	for i, line := range lines {
+		for _, s := range line {
+			if grid[s]&d != 0 {
+				crossMask[i] |= uint64(1) << uint(crossIndex(s))
+			}
+		}
+	}
+	for i := 0; i < n; i++ {
+		if bits.OnesCount64(crossMask[i]) != 2 {
+			continue
+		}
+		for j := i + 1; j < n; j++ {
+			if crossMask[j] != crossMask[i] {
+				continue
+			}
+			for k := 0; k < n; k++ {
+				if k == i || k == j {
+					continue
+				}
+				for _, s := range lines[k] {
+					if crossMask[i]&(uint64(1)<<uint(crossIndex(s))) != 0 && grid[s]&d != 0 {
+						if !b.eliminate(grid, s, d) {
+							return false
+						}
+					}
+				}
+			}
+		}
 	}
 	return true
 }
 
+//////////////////////////////// Solvers ////////////////////////////////
+
+// Solver finds a grid that completes puzzle, filling every blank square
+// while leaving the given squares untouched, or returns nil if no such
+// grid exists. solveList picks one Solver implementation per file or
+// puzzle list, selected by the -A option.
+type Solver interface {
+	Solve(puzzle []uint64) []uint64
+}
+
+// resolveAlgorithm settles on "csp" or "dlx" for a puzzle list, as selected
+// by -A. sample, if non-nil, is used by "-Aauto" to time both backends on a
+// representative puzzle and keep whichever was faster for the rest of the
+// list. It returns a name rather than a Solver so that solveList's worker
+// pool can give each worker its own Solver instance of that kind.
+func (b *Board) resolveAlgorithm(sample []uint64) string {
+	switch algorithm {
+	case "dlx":
+		return "dlx"
+	case "auto":
+		if sample == nil {
+			return "csp"
+		}
+		// Solving sample here is only for timing; save/restore backtracks so
+		// this trial run doesn't inflate the real solve's reported count.
+		saved := atomic.LoadInt64(&backtracks)
+		start := time.Now()
+		NewCSPSolver(b).Solve(sample)
+		cspTime := time.Since(start)
+		start = time.Now()
+		NewDLXSolver(b).Solve(sample)
+		dlxTime := time.Since(start)
+		atomic.StoreInt64(&backtracks, saved)
+		if dlxTime < cspTime {
+			return "dlx"
+		}
+		return "csp"
+	default:
+		return "csp"
+	}
+}
+
+// newSolverForAlgo builds a fresh Solver of the given kind ("csp" or
+// "dlx"); each solveList worker gets its own, so a CSPSolver's gridpool is
+// never shared between goroutines.
+func (b *Board) newSolverForAlgo(algo string) Solver {
+	if algo == "dlx" {
+		return NewDLXSolver(b)
+	}
+	return NewCSPSolver(b)
+}
+
+// CSPSolver is the original recursive search / constraint-propagation
+// backend, selected with -Acsp (the default). It keeps its own gridpool so
+// that repeated Solve calls don't re-allocate one scratch grid per level,
+// and its own backtracks counter so that concurrent solveList workers
+// (each with their own CSPSolver) don't have to share one.
+type CSPSolver struct {
+	b          *Board
+	gridpool   [][]uint64
+	backtracks int64
+}
+
+// NewCSPSolver builds a CSPSolver with a gridpool sized for b.
+func NewCSPSolver(b *Board) *CSPSolver {
+	n2 := b.N * b.N
+	gridpool := make([][]uint64, n2)
+	for i := range gridpool {
+		gridpool[i] = make([]uint64, n2)
+	}
+	return &CSPSolver{b: b, gridpool: gridpool}
+}
+
+// Solve runs constraint propagation, then -s search, starting from puzzle.
+// Resets cs.Backtracks() to 0 first, so it reports only this call's count.
+func (cs *CSPSolver) Solve(puzzle []uint64) []uint64 {
+	cs.backtracks = 0
+	solution := cs.b.initialize(puzzle)
+	if runSearch {
+		solution = cs.b.search(solution, cs.gridpool, 0, &cs.backtracks)
+	}
+	return solution
+}
+
+// Backtracks returns the number of backtracks the most recent Solve call
+// took.
+func (cs *CSPSolver) Backtracks() int64 {
+	return cs.backtracks
+}
+
+// DLXSolver encodes a puzzle as the classic exact-cover problem (324
+// columns / 729 rows for 9x9, generalised to 4*N*N columns / N*N*N rows)
+// and solves it with Knuth's Algorithm X via dancing links, selected with
+// -Adlx.
+type DLXSolver struct {
+	b *Board
+}
+
+// NewDLXSolver builds a DLXSolver for b.
+func NewDLXSolver(b *Board) *DLXSolver {
+	return &DLXSolver{b: b}
+}
+
+// Solve returns the first solution found, or nil if puzzle is unsolvable.
+func (ds *DLXSolver) Solve(puzzle []uint64) []uint64 {
+	solutions := ds.SolveN(puzzle, 1)
+	if len(solutions) == 0 {
+		return nil
+	}
+	return solutions[0]
+}
+
+// SolveN returns up to limit solutions to puzzle (limit <= 0 means find
+// them all). This is what -count=<n> exposes, and what the puzzle
+// generator uses to check uniqueness (look for a 2nd solution).
+func (ds *DLXSolver) SolveN(puzzle []uint64, limit int) [][]uint64 {
+	m := ds.buildMatrix(puzzle)
+	var rowSets [][]int
+	m.search(nil, limit, &rowSets)
+
+	n := ds.b.N
+	solutions := make([][]uint64, len(rowSets))
+	for i, rows := range rowSets {
+		grid := make([]uint64, n*n)
+		copy(grid, puzzle)
+		for _, option := range rows {
+			s, d := option/n, option%n
+			grid[s] = ds.b.Digits[d]
+		}
+		solutions[i] = grid
+	}
+	return solutions
+}
+
+// optionCols returns the 4 exact-cover columns the option "square s holds
+// digit d" covers: cell s is filled, s's row holds d, s's column holds d,
+// and s's block holds d.
+func (b *Board) optionCols(s, d int) [4]int {
+	n := b.N
+	r, c := s/n, s%n
+	return [4]int{
+		r*n + c,
+		n*n + r*n + d,
+		2*n*n + c*n + d,
+		3*n*n + b.Box[s]*n + d,
+	}
+}
+
+// buildMatrix encodes puzzle as an exact-cover matrix with one option row
+// per (square, possible digit) and covers the columns of every already
+// filled square up front, so the search only has to place the blanks.
+func (ds *DLXSolver) buildMatrix(puzzle []uint64) *dlxMatrix {
+	n := ds.b.N
+	m := newDLXMatrix(4 * n * n)
+	for s := 0; s < n*n; s++ {
+		for d := 0; d < n; d++ {
+			if puzzle[s]&ds.b.Digits[d] != 0 {
+				cols := ds.b.optionCols(s, d)
+				m.addRow(s*n+d, cols[:])
+			}
+		}
+	}
+	for s := 0; s < n*n; s++ {
+		if bits.OnesCount64(puzzle[s]) == 1 {
+			d := bits.TrailingZeros64(puzzle[s])
+			for _, c := range ds.b.optionCols(s, d) {
+				m.cover(c)
+			}
+		}
+	}
+	return m
+}
+
+// dlxNode is one node of the sparse 0/1 exact-cover matrix, using int
+// indices into a single []dlxNode rather than pointers so the hot
+// cover/uncover loops stay cache-friendly.
+type dlxNode struct {
+	L, R, U, D int // left, right, up, down neighbours
+	C          int // column header this node belongs to
+	Row        int // the (square, digit) option this node's row encodes, -1 for headers
+}
+
+// dlxMatrix is the exact-cover matrix: columns 0..nCols-1 are headers kept
+// in a circular doubly linked list rooted at "root"; every other node sits
+// in exactly one column's vertical list and one row's horizontal list.
+type dlxMatrix struct {
+	nodes []dlxNode
+	size  []int // size[c] == number of rows still covering column c
+	root  int
+}
+
+// newDLXMatrix creates an empty matrix with nCols column headers.
+func newDLXMatrix(nCols int) *dlxMatrix {
+	m := &dlxMatrix{
+		nodes: make([]dlxNode, nCols+1),
+		size:  make([]int, nCols),
+		root:  nCols,
+	}
+	for c := 0; c <= nCols; c++ {
+		m.nodes[c] = dlxNode{L: c - 1, R: c + 1, U: c, D: c, C: c, Row: -1}
+	}
+	m.nodes[0].L = nCols
+	m.nodes[nCols].R = 0
+	m.nodes[nCols].L = nCols - 1
+	return m
+}
+
+// addRow links a new row node into each of cols's vertical lists and into
+// its own circular horizontal list; rowID identifies the option it encodes.
+func (m *dlxMatrix) addRow(rowID int, cols []int) {
+	first := -1
+	prev := -1
+	for _, c := range cols {
+		idx := len(m.nodes)
+		m.nodes = append(m.nodes, dlxNode{C: c, Row: rowID})
+		top := m.nodes[c].U
+		m.nodes[idx].U = top
+		m.nodes[idx].D = c
+		m.nodes[top].D = idx
+		m.nodes[c].U = idx
+		m.size[c]++
+		if first == -1 {
+			first = idx
+			m.nodes[idx].L = idx
+			m.nodes[idx].R = idx
+		} else {
+			m.nodes[idx].L = prev
+			m.nodes[idx].R = first
+			m.nodes[prev].R = idx
+			m.nodes[first].L = idx
+		}
+		prev = idx
+	}
+}
+
+// cover removes column c from the header list and, for every row that
+// passes through c, removes that row's other nodes from their columns too
+// -- those rows are now inconsistent with c being satisfied.
+func (m *dlxMatrix) cover(c int) {
+	m.nodes[m.nodes[c].R].L = m.nodes[c].L
+	m.nodes[m.nodes[c].L].R = m.nodes[c].R
+	for i := m.nodes[c].D; i != c; i = m.nodes[i].D {
+		for j := m.nodes[i].R; j != i; j = m.nodes[j].R {
+			m.nodes[m.nodes[j].U].D = m.nodes[j].D
+			m.nodes[m.nodes[j].D].U = m.nodes[j].U
+			m.size[m.nodes[j].C]--
+		}
+	}
+}
+
+// uncover reverses a matching cover(c), in strict LIFO order with it.
+func (m *dlxMatrix) uncover(c int) {
+	for i := m.nodes[c].U; i != c; i = m.nodes[i].U {
+		for j := m.nodes[i].L; j != i; j = m.nodes[j].L {
+			m.size[m.nodes[j].C]++
+			m.nodes[m.nodes[j].U].D = j
+			m.nodes[m.nodes[j].D].U = j
+		}
+	}
+	m.nodes[m.nodes[c].R].L = c
+	m.nodes[m.nodes[c].L].R = c
+}
+
+// chooseColumn applies the S-heuristic: pick the column with the fewest
+// remaining rows, to keep Algorithm X's branching factor small.
+func (m *dlxMatrix) chooseColumn() int {
+	best := m.nodes[m.root].R
+	for c := m.nodes[best].R; c != m.root; c = m.nodes[c].R {
+		if m.size[c] < m.size[best] {
+			best = c
+		}
+	}
+	return best
+}
+
+// search runs Algorithm X, appending up to limit solutions (limit <= 0
+// means unlimited) to *out as slices of row ids.
+func (m *dlxMatrix) search(partial []int, limit int, out *[][]int) {
+	if limit > 0 && len(*out) >= limit {
+		return
+	}
+	if m.nodes[m.root].R == m.root {
+		solution := make([]int, len(partial))
+		copy(solution, partial)
+		*out = append(*out, solution)
+		return
+	}
+	c := m.chooseColumn()
+	if m.size[c] == 0 {
+		return // dead end: no row can satisfy this constraint
+	}
+	m.cover(c)
+	for r := m.nodes[c].D; r != c; r = m.nodes[r].D {
+		partial = append(partial, m.nodes[r].Row)
+		for j := m.nodes[r].R; j != r; j = m.nodes[j].R {
+			m.cover(m.nodes[j].C)
+		}
+		m.search(partial, limit, out)
+		for j := m.nodes[r].L; j != r; j = m.nodes[j].L {
+			m.uncover(m.nodes[j].C)
+		}
+		partial = partial[:len(partial)-1]
+		if limit > 0 && len(*out) >= limit {
+			break
+		}
+	}
+	m.uncover(c)
+}
+
+//////////////////////////////// Generator ////////////////////////////////
+
+// clueTargets maps a -gen difficulty level to the number of clues (filled
+// squares) left in the grid, roughly matching the rule of thumb used by
+// Norvig's generator: easy puzzles keep the most clues, evil the fewest.
+var clueTargets = map[string]int{
+	"easy":   36,
+	"medium": 30,
+	"hard":   25,
+	"evil":   22,
+}
+
+// generate emits count puzzles of the given difficulty, one gridstring per
+// line on stdout (so the output composes with solveFile: write it to a
+// file and solve that). For each puzzle it also reports, on stderr, the
+// number of clues left and the backtracks a csp search needs to solve it,
+// as a rough difficulty measure.
+func (b *Board) generate(level string, count int, symmetric bool) error {
+	target, ok := clueTargets[level]
+	if !ok {
+		return errors.New("Unknown difficulty (want easy, medium, hard or evil): -gen " + level)
+	}
+	dlx := NewDLXSolver(b)
+	csp := NewCSPSolver(b)
+	for i := 0; i < count; i++ {
+		puzzle := b.generateOne(dlx, target, symmetric)
+		fmt.Println(b.gridString(puzzle))
+		before := backtracks
+		csp.Solve(puzzle)
+		fmt.Fprintf(os.Stderr, "%s #%d: %d clues, %d backtracks to solve\n",
+			level, i+1, b.countClues(puzzle), backtracks-before)
+	}
+	return nil
+}
+
+// generateOne produces a single puzzle with exactly one solution: complete
+// a random full grid, then try removing every square once (in random
+// order, in symmetric 180-degree pairs if requested), keeping a removal
+// only if dlx still finds exactly one solution. Stops early once
+// targetClues is reached.
+func (b *Board) generateOne(dlx *DLXSolver, targetClues int, symmetric bool) []uint64 {
+	puzzle := b.randomSolvedGrid()
+	n2 := b.N * b.N
+	clues := n2
+	for _, s := range rand.Perm(n2) {
+		if clues <= targetClues {
+			break
+		}
+		if puzzle[s] == b.AllDigits {
+			continue // already removed as someone else's symmetric partner
+		}
+		partner := n2 - 1 - s
+		saved, savedPartner := puzzle[s], puzzle[partner]
+		puzzle[s] = b.AllDigits
+		removed := 1
+		if symmetric && partner != s && puzzle[partner] != b.AllDigits {
+			puzzle[partner] = b.AllDigits
+			removed = 2
+		}
+		if len(dlx.SolveN(puzzle, 2)) == 1 {
+			clues -= removed
+		} else {
+			puzzle[s] = saved
+			puzzle[partner] = savedPartner
+		}
+	}
+	return puzzle
+}
+
+// randomSolvedGrid returns a random complete, valid grid: the diagonal
+// blocks don't share a row, column or block with each other, so they can
+// be filled independently with random permutations of the digits; the
+// rest of the grid is then completed with the regular csp search.
+func (b *Board) randomSolvedGrid() []uint64 {
+	puzzle := make([]uint64, b.N*b.N)
+	for i := range puzzle {
+		puzzle[i] = b.AllDigits
+	}
+	if b.BW == b.BH {
+		numBands := b.BW
+		for k := 0; k < numBands; k++ {
+			block := b.AllUnits[2*b.N+k*numBands+k]
+			perm := rand.Perm(b.N)
+			for i, s := range block {
+				puzzle[s] = b.Digits[perm[i]]
+			}
+		}
+	}
+	return NewCSPSolver(b).Solve(puzzle)
+}
+
+// countClues returns the number of already-filled squares in grid.
+func (b *Board) countClues(grid []uint64) int {
+	clues := 0
+	for _, s := range b.Squares {
+		if bits.OnesCount64(grid[s]) == 1 {
+			clues++
+		}
+	}
+	return clues
+}
+
+// gridString renders grid as a gridstring: '.' for blank squares, and the
+// same digit characters parseGrid accepts otherwise. It is the inverse of
+// parseGrid, used to print generated puzzles.
+func (b *Board) gridString(grid []uint64) string {
+	var sb strings.Builder
+	for _, s := range b.Squares {
+		if grid[s] == b.AllDigits {
+			sb.WriteByte('.')
+		} else {
+			sb.WriteString(digitString(grid[s]))
+		}
+	}
+	return sb.String()
+}
+
 //////////////////////////////// Input ////////////////////////////////
 
 // readFile reads one puzzle per file line and returns a List of puzzle grids.
-func readFile(filename string) (grids [][]int, err error) {
+func (b *Board) readFile(filename string) (grids [][]uint64, err error) {
 	f, err := os.Open(filename)
 	if err != nil {
 		return grids, err
 	}
 	defer f.Close()
 
-	grids = make([][]int, 0, 1000)
+	grids = make([][]uint64, 0, 1000)
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		gridString := scanner.Text()
 		if err := scanner.Err(); err != nil {
 			return grids, err
 		}
-		grid, err := parseGrid(gridString)
+		grid, err := b.parseGrid(gridString)
 		if err != nil {
 			return nil, err
 		}
 		grids = append(grids, grid)
 		if reversePuzzle {
 			gridString = reverse(gridString)
-			grid, err = parseGrid(gridString)
+			grid, err = b.parseGrid(gridString)
 			if err != nil {
 				return nil, err
 			}
@@ -445,10 +1384,37 @@ func readFile(filename string) (grids [][]int, err error) {
 	return
 }
 
-// parseGrid parse a gridstring into a puzzle grid: an int[] with values DIGITS[0-9] or ALL_DIGITS.
-func parseGrid(gridString string) (grid []int, err error) {
-	n2 := N * N
-	grid = make([]int, n2)
+// digitChar parses a single gridstring rune into a digit 1..N, as used by
+// parseGrid. '1'-'9' are digits 1-9; 'A'-'Z' and 'a'-'z' are digits 10-35,
+// which is the usual encoding for 16x16/25x25 .sdk files. Returns ok=false
+// for any other rune (blanks are handled separately by the caller).
+func digitChar(r rune) (d int, ok bool) {
+	switch {
+	case r >= '1' && r <= '9':
+		return int(r-'1') + 1, true
+	case r >= 'A' && r <= 'Z':
+		return int(r-'A') + 10, true
+	case r >= 'a' && r <= 'z':
+		return int(r-'a') + 10, true
+	default:
+		return 0, false
+	}
+}
+
+// isGridChar reports whether r is a character parseGrid understands for a
+// board of side n: a blank ('0' or '.') or a digit from 1 to n.
+func isGridChar(r rune, n int) bool {
+	if r == '0' || r == '.' {
+		return true
+	}
+	d, ok := digitChar(r)
+	return ok && d <= n
+}
+
+// parseGrid parse a gridstring into a puzzle grid: a []uint64 with values Digits[0..N-1] or AllDigits.
+func (b *Board) parseGrid(gridString string) (grid []uint64, err error) {
+	n2 := b.N * b.N
+	grid = make([]uint64, n2)
 	gridRunes := []rune(gridString)
 	s := 0
 	d := 0
@@ -457,14 +1423,13 @@ func parseGrid(gridString string) (grid []int, err error) {
 			// debug fmt.Println(d, "s == n2", s)
 			break // Prevent array index out of bounds
 		}
-		if '1' <= r && r <= '9' {
-			// debug fmt.Println(d, "number", r, string(r))
-			c, _ := strconv.Atoi(string(r)) // Atoi works only on '1' to '9'
-			grid[s] = DIGITS[c-1]           // A single-bit set to represent a digit
-			s++
-		} else if r == '0' || r == '.' {
+		if r == '0' || r == '.' {
 			// debug fmt.Println(d, "o .", r, string(r))
-			grid[s] = ALL_DIGITS // Any digit is possible
+			grid[s] = b.AllDigits // Any digit is possible
+			s++
+		} else if dig, ok := digitChar(r); ok && dig <= b.N {
+			// debug fmt.Println(d, "number", r, string(r))
+			grid[s] = b.Digits[dig-1] // A single-bit set to represent a digit
 			s++
 		} else {
 			// debug fmt.Println(d, "skip")
@@ -474,7 +1439,7 @@ func parseGrid(gridString string) (grid []int, err error) {
 	d++
 	if s < n2 {
 		return nil, errors.New(fmt.Sprintf("Line '%v'\n has %v digits, want %v digits.", gridString, s, n2))
-	} else if strings.ContainsAny(string(gridRunes[d:]), ".0123456789") {
+	} else if strings.IndexFunc(string(gridRunes[d:]), func(r rune) bool { return isGridChar(r, b.N) }) >= 0 {
 		// debug fmt.Println(gridRunes[d:], string(gridRunes[d:]), d)
 		return nil, errors.New(fmt.Sprintf("Line '%v'\n has more than %v digits.", gridString, n2))
 	}
@@ -483,17 +1448,17 @@ func parseGrid(gridString string) (grid []int, err error) {
 }
 
 // initialize a grid from a puzzle.
-// First initialize every square in the new grid to ALL_DIGITS, meaning any value is possible.
+// First initialize every square in the new grid to AllDigits, meaning any value is possible.
 // Then, call `fill` on the puzzle's filled squares to initiate constraint propagation.
 // grid can be nil.
-func initialize(puzzle []int) (grid []int) {
-	grid = make([]int, N*N)
+func (b *Board) initialize(puzzle []uint64) (grid []uint64) {
+	grid = make([]uint64, b.N*b.N)
 	for i := range grid {
-		grid[i] = ALL_DIGITS
+		grid[i] = b.AllDigits
 	}
-	for s := range SQUARES {
-		if puzzle[s] != ALL_DIGITS {
-			fill(grid, s, puzzle[s])
+	for _, s := range b.Squares {
+		if puzzle[s] != b.AllDigits {
+			b.fill(grid, s, puzzle[s])
 		}
 	}
 	return grid
@@ -504,11 +1469,28 @@ var headerPrinted = false
 
 // printStats print stats on puzzles solved, average time, frequency, threads used, and name.
 func printStats(nGrids int, startTime time.Time, name string) {
-	t := time.Now()
-	elapsed := t.Sub(startTime)
+	printStatsElapsed(nGrids, time.Since(startTime), name)
+}
+
+// printStatsElapsed is printStats given an already-measured duration, for
+// callers (like solveKenKenFile/solveStarBattleFile) that can't just take
+// a startTime and subtract. It reports (and resets) the shared global
+// backtracks counter, so it's only correct for a caller that owns that
+// counter for the whole duration being reported -- solveList's worker pool
+// does not, and uses printBacktracks directly instead.
+func printStatsElapsed(nGrids int, elapsed time.Duration, name string) {
+	printBacktracks(nGrids, elapsed, atomic.LoadInt64(&backtracks), name)
+	atomic.StoreInt64(&backtracks, 0)
+}
+
+// printBacktracks prints one Puzzles/μsec/kHz/Backtracks/Name stats line
+// for bt backtracks (already attributed to whatever nGrids/elapsed/name
+// cover by the caller), printing the column header first if this is the
+// first line of output.
+func printBacktracks(nGrids int, elapsed time.Duration, bt int64, name string) {
 	usecs := float64(elapsed.Microseconds())
 	ngrd := float64(nGrids)
-	bcktrcks := float64(backtracks) / ngrd
+	bcktrcks := float64(bt) / ngrd
 	line := fmt.Sprintf("%7d %6.1f %7.3f %10.1f %s",
 		nGrids, usecs/ngrd, 1000*ngrd/usecs, bcktrcks, name)
 	if !headerPrinted {
@@ -517,43 +1499,53 @@ func printStats(nGrids int, startTime time.Time, name string) {
 		headerPrinted = true
 	}
 	fmt.Println(line)
-	backtracks = 0
 }
 
 // printGrids print the original puzzle grid and the solution grid.
-func printGrids(name string, puzzle []int, solution []int) {
-	bar := "------+-------+------"
+func (b *Board) printGrids(name string, puzzle []uint64, solution []uint64) {
+	bar := strings.Repeat("-", b.BW*2+1)
+	bar = strings.Repeat(bar+"+", b.BH-1) + strings.Repeat("-", b.BW*2)
 	gap := "      " // Space between the puzzle grid and solution grid
 	if solution == nil {
-		solution = make([]int, N*N)
+		solution = make([]uint64, b.N*b.N)
 	}
 	solfail := "FAILED:"
-	if verify(solution, puzzle) {
+	if b.verify(solution, puzzle) {
 		solfail = "Solution:"
 	}
 	fmt.Printf("\n%-22s%s%s\n", name+":", gap, solfail)
-	for r := 0; r < N; r++ {
-		fmt.Println(rowString(puzzle, r) + gap + rowString(solution, r))
-		if r == 2 || r == 5 {
+	for r := 0; r < b.N; r++ {
+		fmt.Println(b.rowString(puzzle, r) + gap + b.rowString(solution, r))
+		if r < b.N-1 && (r+1)%b.BH == 0 {
 			fmt.Println(bar + gap + " " + bar)
 		}
 	}
 }
 
+// digitString renders the digit stored as a single bit in val using the same
+// character set parseGrid accepts: '1'-'9' then 'A'.. for digits above 9.
+func digitString(val uint64) string {
+	d := bits.TrailingZeros64(val) + 1
+	if d <= 9 {
+		return strconv.Itoa(d)
+	}
+	return string(rune('A' + d - 10))
+}
+
 // rowString return a string representing a row of this puzzle.
-func rowString(grid []int, r int) string {
+func (b *Board) rowString(grid []uint64, r int) string {
 	row := ""
-	for s := r * 9; s < (r+1)*9; s++ {
-		if NUM_DIGITS[grid[s]] == 9 {
+	for s := r * b.N; s < (r+1)*b.N; s++ {
+		count := bits.OnesCount64(grid[s])
+		if count == b.N {
 			row += "."
+		} else if count != 1 {
+			row += "?"
 		} else {
-			if NUM_DIGITS[grid[s]] != 1 {
-				row += "?"
-			} else {
-				row += strconv.Itoa(bits.TrailingZeros(uint(grid[s])) + 1)
-			}
+			row += digitString(grid[s])
 		}
-		if s%9 == 2 || s%9 == 5 {
+		c := s % b.N
+		if c < b.N-1 && (c+1)%b.BW == 0 {
 			row += " | "
 		} else {
 			row += " "
@@ -561,3 +1553,583 @@ func rowString(grid []int, r int) string {
 	}
 	return row
 }
+
+//////////////////////////////// KenKen ////////////////////////////////
+
+// Cage is one KenKen cage: a set of squares whose digits must combine,
+// via Op, to Target. Op is one of '+', '-', '*', '/', or '=' (a
+// single-cell cage that just fixes its value).
+type Cage struct {
+	Cells  []int
+	Op     byte
+	Target int
+}
+
+// KenKenPuzzle is an N x N Latin square (no blocks, so its Board's only
+// units are rows and columns) with a set of Cages layered on top. It
+// reuses Board's bitset grid and selectSquare/fill/eliminate machinery for
+// the Latin-square part of the puzzle, and adds cage-feasibility pruning
+// around the same select/fill/backtrack loop Board.search uses.
+type KenKenPuzzle struct {
+	b     *Board
+	cages []Cage
+}
+
+// NewKenKenPuzzle builds a KenKenPuzzle of size n x n with the given cages.
+// Every cell of an n x n Latin square must end up in some cage, but that's
+// the file format's responsibility to guarantee, not this constructor's.
+func NewKenKenPuzzle(n int, cages []Cage) *KenKenPuzzle {
+	return &KenKenPuzzle{b: NewBoard(1, n), cages: cages}
+}
+
+// solve finds a digit for every square of kk's Latin square, subject to
+// its cages, using the same MRV-select / fill / backtrack loop as
+// Board.search, with cage feasibility checked after every fill so a
+// violated cage prunes the branch immediately instead of only at the end.
+func (kk *KenKenPuzzle) solve() []uint64 {
+	b := kk.b
+	grid := b.initialize(make([]uint64, b.N*b.N)) // blank puzzle: every cell starts open
+	gridpool := make([][]uint64, b.N*b.N+1)
+	for i := range gridpool {
+		gridpool[i] = make([]uint64, b.N*b.N)
+	}
+	return kk.search(grid, gridpool, 0)
+}
+
+// search mirrors Board.search, but also rejects a fill that leaves some
+// fully-determined cage violating its operator and target.
+func (kk *KenKenPuzzle) search(grid []uint64, gridpool [][]uint64, level int) []uint64 {
+	if grid == nil {
+		return nil
+	}
+	b := kk.b
+	s := b.selectSquare(grid)
+	if s == -1 {
+		return grid // No squares to select means we are done!
+	}
+	for _, d := range b.Digits {
+		if (d & grid[s]) > 0 {
+			copy(gridpool[level], grid)
+			filled := b.fill(gridpool[level], s, d)
+			if filled != nil && !kk.cagesConsistent(filled) {
+				filled = nil
+			}
+			result := kk.search(filled, gridpool, level+1)
+			if result != nil {
+				return result
+			}
+			atomic.AddInt64(&backtracks, 1)
+		}
+	}
+	return nil
+}
+
+// cagesConsistent reports whether every cage of kk that is fully
+// determined in grid (every one of its cells reduced to a single digit)
+// satisfies its operator and target. A cage with any undetermined cell is
+// not yet checked, and so can't fail this check.
+func (kk *KenKenPuzzle) cagesConsistent(grid []uint64) bool {
+	for _, cage := range kk.cages {
+		values := make([]int, 0, len(cage.Cells))
+		for _, s := range cage.Cells {
+			if bits.OnesCount64(grid[s]) != 1 {
+				values = nil
+				break
+			}
+			values = append(values, bits.TrailingZeros64(grid[s])+1)
+		}
+		if values != nil && !cageSatisfied(cage, values) {
+			return false
+		}
+	}
+	return true
+}
+
+// cageSatisfied reports whether values (one per cage.Cells, in the same
+// order) combine via cage.Op to cage.Target. '-' and '/' only make sense
+// for two-cell cages, and try both orderings since cage cell order isn't
+// meaningful for them.
+func cageSatisfied(cage Cage, values []int) bool {
+	switch cage.Op {
+	case '+':
+		sum := 0
+		for _, v := range values {
+			sum += v
+		}
+		return sum == cage.Target
+	case '*':
+		product := 1
+		for _, v := range values {
+			product *= v
+		}
+		return product == cage.Target
+	case '-':
+		lo, hi := values[0], values[1]
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		return hi-lo == cage.Target
+	case '/':
+		lo, hi := values[0], values[1]
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		return hi%lo == 0 && hi/lo == cage.Target
+	case '=':
+		return len(values) == 1 && values[0] == cage.Target
+	}
+	return false
+}
+
+// parseKenKenFile reads one KenKen puzzle from filename. The format is:
+//
+//	N
+//	TARGET OP R1C1 R2C1 ...
+//	...
+//
+// one cage per line after the first, cells given 1-indexed as RrCc, and OP
+// one of + - * / =. A blank line ends the puzzle; only the first puzzle in
+// the file is read, matching -gen's one-puzzle-at-a-time style.
+func parseKenKenFile(filename string) (*KenKenPuzzle, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return nil, errors.New("KenKen file " + filename + " is empty")
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || n < 1 {
+		return nil, errors.New("Bad KenKen size: " + scanner.Text())
+	}
+
+	var cages []Cage
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			break
+		}
+		cage, err := parseCage(line, n)
+		if err != nil {
+			return nil, err
+		}
+		cages = append(cages, cage)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewKenKenPuzzle(n, cages), nil
+}
+
+// parseCage parses one "TARGET OP RrCc RrCc ..." cage line for an n x n
+// KenKen.
+func parseCage(line string, n int) (Cage, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return Cage{}, errors.New("Bad cage line: " + line)
+	}
+	target, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return Cage{}, errors.New("Bad cage target: " + line)
+	}
+	op := fields[1]
+	if len(op) != 1 || !strings.ContainsRune("+-*/=", rune(op[0])) {
+		return Cage{}, errors.New("Bad cage operator: " + line)
+	}
+	cells := make([]int, 0, len(fields)-2)
+	for _, cellStr := range fields[2:] {
+		r, c, err := parseCellRef(cellStr, n)
+		if err != nil {
+			return Cage{}, err
+		}
+		cells = append(cells, r*n+c)
+	}
+	switch op[0] {
+	case '-', '/':
+		if len(cells) != 2 {
+			return Cage{}, errors.New("Cage operator " + op + " needs exactly 2 cells: " + line)
+		}
+	case '=':
+		if len(cells) != 1 {
+			return Cage{}, errors.New("Cage operator = needs exactly 1 cell: " + line)
+		}
+	}
+	return Cage{Cells: cells, Op: op[0], Target: target}, nil
+}
+
+// parseCellRef parses a 1-indexed "RrCc" cell reference (e.g. "R2C3") into
+// 0-indexed row and column, both required to be within an n x n grid.
+func parseCellRef(s string, n int) (r, c int, err error) {
+	s = strings.ToUpper(s)
+	var cIdx int
+	if _, scanErr := fmt.Sscanf(s, "R%dC%d", &r, &cIdx); scanErr != nil {
+		return 0, 0, errors.New("Bad cell reference: " + s)
+	}
+	r, c = r-1, cIdx-1
+	if r < 0 || r >= n || c < 0 || c >= n {
+		return 0, 0, errors.New("Cell reference out of range: " + s)
+	}
+	return r, c, nil
+}
+
+// solveKenKenFile parses and solves the KenKen puzzle in filename, printing
+// its solution grid the same way a Sudoku puzzle's grid is printed.
+func solveKenKenFile(filename string) error {
+	kk, err := parseKenKenFile(filename)
+	if err != nil {
+		return err
+	}
+	startTime := time.Now()
+	solution := kk.solve()
+	if printFileStats {
+		printStats(1, startTime, filename)
+	}
+	if solution == nil {
+		fmt.Println("No solution found for " + filename)
+		return nil
+	}
+	for r := 0; r < kk.b.N; r++ {
+		fmt.Println(kk.b.rowString(solution, r))
+	}
+	return nil
+}
+
+//////////////////////////////// Star Battle ////////////////////////////////
+
+// starCandidate and starEmpty are the two bits a Star Battle cell's bitset
+// can hold, analogous to the per-digit bits Board uses for Sudoku: 1 means
+// a star could still go there, 2 means the cell could still be left empty.
+// A solved cell holds exactly one of the two.
+const (
+	starCandidate uint64 = 1 << iota
+	starEmpty
+)
+
+// StarBattlePuzzle is an N x N grid partitioned into N regions, where
+// exactly K stars must be placed in every row, column and region, and no
+// two stars may touch, even diagonally. It reuses the same per-cell
+// bitset + propagate + search architecture as Board, with rows, columns
+// and regions standing in for Board's row/column/block units and
+// "exactly K" standing in for Board's "exactly one".
+type StarBattlePuzzle struct {
+	n       int
+	k       int
+	regions []int // regions[s] is the 0..n-1 region index of square s
+}
+
+// NewStarBattlePuzzle builds a Star Battle puzzle of size n x n requiring
+// k stars per row, column and region.
+func NewStarBattlePuzzle(n, k int, regions []int) *StarBattlePuzzle {
+	return &StarBattlePuzzle{n: n, k: k, regions: regions}
+}
+
+// neighbors returns the up to 8 squares orthogonally or diagonally
+// adjacent to square s, which may not also hold a star.
+func (sb *StarBattlePuzzle) neighbors(s int) []int {
+	r, c := s/sb.n, s%sb.n
+	var ns []int
+	for dr := -1; dr <= 1; dr++ {
+		for dc := -1; dc <= 1; dc++ {
+			if dr == 0 && dc == 0 {
+				continue
+			}
+			nr, nc := r+dr, c+dc
+			if nr >= 0 && nr < sb.n && nc >= 0 && nc < sb.n {
+				ns = append(ns, nr*sb.n+nc)
+			}
+		}
+	}
+	return ns
+}
+
+// rowUnit, colUnit and regionUnit return the squares sharing a row,
+// column or region (respectively) with square s, mirroring Board.Units.
+func (sb *StarBattlePuzzle) rowUnit(s int) []int {
+	r := s / sb.n
+	unit := make([]int, sb.n)
+	for c := 0; c < sb.n; c++ {
+		unit[c] = r*sb.n + c
+	}
+	return unit
+}
+
+func (sb *StarBattlePuzzle) colUnit(s int) []int {
+	c := s % sb.n
+	unit := make([]int, sb.n)
+	for r := 0; r < sb.n; r++ {
+		unit[r] = r*sb.n + c
+	}
+	return unit
+}
+
+func (sb *StarBattlePuzzle) regionUnit(s int) []int {
+	region := sb.regions[s]
+	var unit []int
+	for t, reg := range sb.regions {
+		if reg == region {
+			unit = append(unit, t)
+		}
+	}
+	return unit
+}
+
+// place sets grid[s] to a star, and propagates its consequences: s's
+// neighbors can no longer hold a star, and any row/column/region that now
+// has its full quota of k stars has all its remaining open cells forced
+// empty. Returns nil if placing the star leads to a contradiction.
+func (sb *StarBattlePuzzle) place(grid []uint64, s int) []uint64 {
+	if grid == nil || grid[s]&starCandidate == 0 {
+		return nil
+	}
+	grid[s] = starCandidate
+	for _, p := range sb.neighbors(s) {
+		if !sb.forbidStar(grid, p) {
+			return nil
+		}
+	}
+	for _, unit := range [][]int{sb.rowUnit(s), sb.colUnit(s), sb.regionUnit(s)} {
+		if !sb.enforceQuota(grid, unit) {
+			return nil
+		}
+	}
+	return grid
+}
+
+// forbidStar removes starCandidate from grid[s], failing if that leaves
+// grid[s] with no possibility at all. Forcing a cell empty can itself
+// complete another unit's quota (the cell forced empty here belongs to a
+// row, column and region of its own, not just the unit enforceQuota is
+// currently scanning), so this re-runs enforceQuota on all three of s's
+// units whenever it actually changes s, propagating to a fixed point
+// rather than leaving those units unchecked.
+func (sb *StarBattlePuzzle) forbidStar(grid []uint64, s int) bool {
+	if grid[s]&starCandidate == 0 {
+		return true // already decided; nothing new to propagate
+	}
+	grid[s] &^= starCandidate
+	if grid[s] == 0 {
+		return false
+	}
+	for _, unit := range [][]int{sb.rowUnit(s), sb.colUnit(s), sb.regionUnit(s)} {
+		if !sb.enforceQuota(grid, unit) {
+			return false
+		}
+	}
+	return true
+}
+
+// enforceQuota checks unit's star count against sb.k: too many stars is a
+// contradiction; exactly k forces every other open cell in unit empty; too
+// few stars with too few cells still open to reach k is also a
+// contradiction.
+func (sb *StarBattlePuzzle) enforceQuota(grid []uint64, unit []int) bool {
+	stars, open := 0, 0
+	for _, s := range unit {
+		if grid[s] == starCandidate {
+			stars++
+		} else if grid[s]&starCandidate != 0 {
+			open++
+		}
+	}
+	if stars > sb.k || stars+open < sb.k {
+		return false
+	}
+	if stars == sb.k {
+		for _, s := range unit {
+			if grid[s]&starCandidate != 0 && grid[s] != starCandidate {
+				if !sb.forbidStar(grid, s) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// solve searches for a placement of stars satisfying every row, column and
+// region's quota, using MRV over remaining-undecided squares the same way
+// Board.search does over remaining-undecided digits.
+func (sb *StarBattlePuzzle) solve() []uint64 {
+	grid := make([]uint64, sb.n*sb.n)
+	for i := range grid {
+		grid[i] = starCandidate | starEmpty
+	}
+	gridpool := make([][]uint64, sb.n*sb.n+1)
+	for i := range gridpool {
+		gridpool[i] = make([]uint64, sb.n*sb.n)
+	}
+	return sb.search(grid, gridpool, 0)
+}
+
+// search mirrors Board.search: pick an undecided square, try placing a
+// star there, and backtrack on failure; trying "no star" is handled by
+// enforceQuota forcing cells empty once a unit hits its quota, so the only
+// choice search itself makes is where to try a star next.
+func (sb *StarBattlePuzzle) search(grid []uint64, gridpool [][]uint64, level int) []uint64 {
+	if grid == nil {
+		return nil
+	}
+	s := sb.selectSquare(grid)
+	if s == -1 {
+		if !sb.verify(grid) {
+			return nil // Every cell decided, but some unit missed its quota.
+		}
+		return grid // No undecided squares means we are done!
+	}
+	if grid[s]&starCandidate != 0 {
+		copy(gridpool[level], grid)
+		if result := sb.search(sb.place(gridpool[level], s), gridpool, level+1); result != nil {
+			return result
+		}
+		atomic.AddInt64(&backtracks, 1)
+	}
+	copy(gridpool[level], grid)
+	gridpool[level][s] = starEmpty
+	if result := sb.search(gridpool[level], gridpool, level+1); result != nil {
+		return result
+	}
+	atomic.AddInt64(&backtracks, 1)
+	return nil
+}
+
+// selectSquare returns an undecided square (one that could still be either
+// a star or empty), or -1 if every square has been decided.
+func (sb *StarBattlePuzzle) selectSquare(grid []uint64) int {
+	for s, v := range grid {
+		if v == starCandidate|starEmpty {
+			return s
+		}
+	}
+	return -1
+}
+
+// verify reports whether a fully-decided grid is an actual solution: every
+// row, column and region has exactly k stars, and no two stars touch, even
+// diagonally. This is the authoritative check search relies on once every
+// cell is decided, since forcing a cell empty doesn't always re-propagate
+// through every unit that cell belongs to.
+func (sb *StarBattlePuzzle) verify(grid []uint64) bool {
+	for s, v := range grid {
+		if v == starCandidate {
+			for _, p := range sb.neighbors(s) {
+				if grid[p] == starCandidate {
+					return false
+				}
+			}
+		}
+	}
+	for r := 0; r < sb.n; r++ {
+		if !sb.unitHasQuota(grid, sb.rowUnit(r*sb.n)) {
+			return false
+		}
+	}
+	for c := 0; c < sb.n; c++ {
+		if !sb.unitHasQuota(grid, sb.colUnit(c)) {
+			return false
+		}
+	}
+	seen := make(map[int]bool, sb.n)
+	for s := range grid {
+		region := sb.regions[s]
+		if seen[region] {
+			continue
+		}
+		seen[region] = true
+		if !sb.unitHasQuota(grid, sb.regionUnit(s)) {
+			return false
+		}
+	}
+	return true
+}
+
+// unitHasQuota reports whether unit contains exactly k stars.
+func (sb *StarBattlePuzzle) unitHasQuota(grid []uint64, unit []int) bool {
+	stars := 0
+	for _, s := range unit {
+		if grid[s] == starCandidate {
+			stars++
+		}
+	}
+	return stars == sb.k
+}
+
+// parseStarBattleFile reads one Star Battle puzzle from filename. The
+// format is:
+//
+//	N K
+//	N lines of N region letters (A, B, C, ...), one grid row per line
+//
+// Only the first puzzle in the file is read, matching -gen and -K's
+// one-puzzle-at-a-time style.
+func parseStarBattleFile(filename string) (*StarBattlePuzzle, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return nil, errors.New("Star Battle file " + filename + " is empty")
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) != 2 {
+		return nil, errors.New("Bad Star Battle header (want \"N K\"): " + scanner.Text())
+	}
+	n, err1 := strconv.Atoi(fields[0])
+	k, err2 := strconv.Atoi(fields[1])
+	if err1 != nil || err2 != nil || n < 1 || k < 1 {
+		return nil, errors.New("Bad Star Battle header (want \"N K\"): " + scanner.Text())
+	}
+
+	regions := make([]int, n*n)
+	for r := 0; r < n; r++ {
+		if !scanner.Scan() {
+			return nil, errors.New("Star Battle file has too few region rows")
+		}
+		row := strings.TrimSpace(scanner.Text())
+		if len(row) != n {
+			return nil, errors.New("Star Battle region row has wrong length: " + row)
+		}
+		for c, ch := range row {
+			regions[r*n+c] = int(ch - 'A')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewStarBattlePuzzle(n, k, regions), nil
+}
+
+// solveStarBattleFile parses and solves the Star Battle puzzle in
+// filename, printing a grid of '*' for stars and '.' for empty cells.
+func solveStarBattleFile(filename string) error {
+	sb, err := parseStarBattleFile(filename)
+	if err != nil {
+		return err
+	}
+	startTime := time.Now()
+	solution := sb.solve()
+	if printFileStats {
+		printStats(1, startTime, filename)
+	}
+	if solution == nil {
+		fmt.Println("No solution found for " + filename)
+		return nil
+	}
+	for r := 0; r < sb.n; r++ {
+		row := ""
+		for c := 0; c < sb.n; c++ {
+			if solution[r*sb.n+c] == starCandidate {
+				row += "* "
+			} else {
+				row += ". "
+			}
+		}
+		fmt.Println(row)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+// TestNewBoardSizes checks that NewBoard builds correctly-shaped geometry
+// for the classic 9x9 board as well as the 4x4 and 16x16 variable sizes
+// added for -N.
+func TestNewBoardSizes(t *testing.T) {
+	for _, tc := range []struct {
+		bh, bw, wantN int
+	}{
+		{3, 3, 9},
+		{2, 2, 4},
+		{4, 4, 16},
+	} {
+		b := NewBoard(tc.bh, tc.bw)
+		if b.N != tc.wantN {
+			t.Fatalf("NewBoard(%d, %d).N = %d, want %d", tc.bh, tc.bw, b.N, tc.wantN)
+		}
+		if len(b.Squares) != tc.wantN*tc.wantN {
+			t.Errorf("NewBoard(%d, %d) has %d squares, want %d", tc.bh, tc.bw, len(b.Squares), tc.wantN*tc.wantN)
+		}
+		if len(b.AllUnits) != 3*tc.wantN {
+			t.Errorf("NewBoard(%d, %d) has %d units, want %d", tc.bh, tc.bw, len(b.AllUnits), 3*tc.wantN)
+		}
+		// Peers dedupes squares shared by more than one unit: row and column
+		// peers never overlap, but the block overlaps (bw-1) of the row's
+		// peers and (bh-1) of the column's.
+		wantPeers := 3*(tc.wantN-1) - (tc.bw - 1) - (tc.bh - 1)
+		for _, s := range b.Squares {
+			if len(b.Peers[s]) != wantPeers {
+				t.Fatalf("NewBoard(%d, %d) square %d has %d peers, want %d", tc.bh, tc.bw, s, len(b.Peers[s]), wantPeers)
+			}
+		}
+	}
+}
+
+// TestParseGridAndSolve4x4 exercises parseGrid and the csp solver end to
+// end on a minimal 4x4 puzzle, the simplest variable-size case.
+func TestParseGridAndSolve4x4(t *testing.T) {
+	b := NewBoard(2, 2)
+	puzzle, err := b.parseGrid("1...2.....3....4")
+	if err != nil {
+		t.Fatalf("parseGrid: %v", err)
+	}
+	solution := NewCSPSolver(b).Solve(puzzle)
+	if solution == nil {
+		t.Fatal("Solve returned nil for a solvable 4x4 puzzle")
+	}
+	if !b.verify(solution, puzzle) {
+		t.Errorf("verify rejected the csp solver's own solution")
+	}
+}
+
+// TestParseGridRejectsWrongLength checks parseGrid's error path for a
+// gridstring with too few digits for the board size.
+func TestParseGridRejectsWrongLength(t *testing.T) {
+	b := NewBoard(2, 2)
+	if _, err := b.parseGrid("123"); err == nil {
+		t.Error("parseGrid accepted a gridstring shorter than N*N")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+// easy9x9 is a standard 9x9 puzzle with a unique solution, used to check
+// that the dlx backend agrees with the csp backend.
+const easy9x9 = "53..7....6..195....98....6.8...6...34..8.3..17...2...6.6....28....419..5....8..79"
+
+// TestDLXMatchesCSP checks that -Adlx and the default csp backend find the
+// same solution for the same puzzle, since both are meant to implement the
+// same Solver contract.
+func TestDLXMatchesCSP(t *testing.T) {
+	b := NewBoard(3, 3)
+	puzzle, err := b.parseGrid(easy9x9)
+	if err != nil {
+		t.Fatalf("parseGrid: %v", err)
+	}
+	cspSolution := NewCSPSolver(b).Solve(puzzle)
+	dlxSolution := NewDLXSolver(b).Solve(puzzle)
+	if cspSolution == nil || dlxSolution == nil {
+		t.Fatalf("solvers disagreed on solvability: csp=%v dlx=%v", cspSolution != nil, dlxSolution != nil)
+	}
+	for s := range puzzle {
+		if cspSolution[s] != dlxSolution[s] {
+			t.Fatalf("csp and dlx solutions differ at square %d: %v vs %v", s, cspSolution[s], dlxSolution[s])
+		}
+	}
+	if !b.verify(dlxSolution, puzzle) {
+		t.Error("verify rejected the dlx solver's own solution")
+	}
+}
+
+// TestDLXSolveNCountsSolutions checks SolveN's early-stop-at-limit behavior
+// against a puzzle with more than one solution (an almost-blank 4x4 board).
+func TestDLXSolveNCountsSolutions(t *testing.T) {
+	b := NewBoard(2, 2)
+	puzzle := make([]uint64, b.N*b.N)
+	for i := range puzzle {
+		puzzle[i] = b.AllDigits
+	}
+	dlx := NewDLXSolver(b)
+	if got := len(dlx.SolveN(puzzle, 2)); got != 2 {
+		t.Errorf("SolveN(blank, 2) found %d solutions, want 2", got)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+// TestGenerateOneIsUniqueAndSolvable checks that generateOne's output has
+// exactly one solution (the property -gen exists to preserve) and that the
+// csp solver agrees with it.
+func TestGenerateOneIsUniqueAndSolvable(t *testing.T) {
+	b := NewBoard(2, 2) // 4x4, so the test runs fast
+	dlx := NewDLXSolver(b)
+	puzzle := b.generateOne(dlx, clueTargets["easy"], false)
+
+	solutions := dlx.SolveN(puzzle, 2)
+	if len(solutions) != 1 {
+		t.Fatalf("generateOne produced a puzzle with %d solutions, want exactly 1", len(solutions))
+	}
+
+	cspSolution := NewCSPSolver(b).Solve(puzzle)
+	if !b.verify(cspSolution, puzzle) {
+		t.Error("csp solver's solution to the generated puzzle failed verify")
+	}
+}
+
+// TestGenerateRejectsUnknownLevel checks generate's error path for a
+// difficulty name not in clueTargets.
+func TestGenerateRejectsUnknownLevel(t *testing.T) {
+	b := NewBoard(3, 3)
+	if err := b.generate("impossible", 1, false); err == nil {
+		t.Error("generate accepted an unknown difficulty level")
+	}
+}
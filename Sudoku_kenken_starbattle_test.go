@@ -0,0 +1,120 @@
+package main
+
+import "testing"
+
+// TestCageSatisfied checks cageSatisfied's arithmetic for each operator,
+// including the order-independence of '-' and '/'.
+func TestCageSatisfied(t *testing.T) {
+	for _, tc := range []struct {
+		op     byte
+		target int
+		values []int
+		want   bool
+	}{
+		{'+', 7, []int{3, 4}, true},
+		{'+', 7, []int{3, 5}, false},
+		{'*', 12, []int{3, 4}, true},
+		{'-', 2, []int{3, 1}, true}, // order shouldn't matter
+		{'-', 2, []int{1, 3}, true},
+		{'/', 3, []int{1, 3}, true},
+		{'/', 4, []int{2, 6}, false}, // 6/2 == 3, not 4
+		{'=', 5, []int{5}, true},
+		{'=', 5, []int{4}, false},
+	} {
+		cage := Cage{Op: tc.op, Target: tc.target}
+		if got := cageSatisfied(cage, tc.values); got != tc.want {
+			t.Errorf("cageSatisfied(%c, %d, %v) = %v, want %v", tc.op, tc.target, tc.values, got, tc.want)
+		}
+	}
+}
+
+// TestParseCageRejectsMismatchedCellCount checks that parseCage returns an
+// error instead of building a cage that would later panic in
+// cageSatisfied, for every operator that requires a fixed cell count.
+func TestParseCageRejectsMismatchedCellCount(t *testing.T) {
+	for _, line := range []string{
+		"3 - R1C1",           // '-' needs exactly 2 cells
+		"3 - R1C1 R1C2 R1C3", // '-' needs exactly 2 cells
+		"3 / R1C1",           // '/' needs exactly 2 cells
+		"5 = R1C1 R1C2",      // '=' needs exactly 1 cell
+	} {
+		if _, err := parseCage(line, 4); err == nil {
+			t.Errorf("parseCage(%q) accepted a cell count its operator can't use", line)
+		}
+	}
+}
+
+// TestParseCageAcceptsValidCages checks parseCage's happy path for each
+// operator it supports.
+func TestParseCageAcceptsValidCages(t *testing.T) {
+	for _, line := range []string{
+		"7 + R1C1 R1C2",
+		"12 * R1C1 R1C2 R2C1",
+		"2 - R1C1 R1C2",
+		"3 / R1C1 R1C2",
+		"4 = R1C1",
+	} {
+		cage, err := parseCage(line, 4)
+		if err != nil {
+			t.Errorf("parseCage(%q) = %v, want no error", line, err)
+			continue
+		}
+		if len(cage.Cells) == 0 {
+			t.Errorf("parseCage(%q) produced a cage with no cells", line)
+		}
+	}
+}
+
+// TestKenKenSolve solves a small KenKen puzzle and checks every row and
+// column is a permutation of 1..n and every cage is satisfied, mirroring
+// what Board.verify checks for plain Sudoku.
+func TestKenKenSolve(t *testing.T) {
+	cages := []Cage{
+		{Cells: []int{0, 1}, Op: '+', Target: 3},   // R1C1 R1C2: 1+2
+		{Cells: []int{2, 3}, Op: '+', Target: 7},   // R1C3 R1C4: 3+4
+		{Cells: []int{4, 5}, Op: '-', Target: 1},   // R2C1 R2C2: 2-1
+		{Cells: []int{6, 7}, Op: '+', Target: 7},   // R2C3 R2C4: 4+3
+		{Cells: []int{8, 9}, Op: '+', Target: 7},   // R3C1 R3C2: 3+4
+		{Cells: []int{10, 11}, Op: '+', Target: 3}, // R3C3 R3C4: 1+2
+		{Cells: []int{12, 13}, Op: '-', Target: 1}, // R4C1 R4C2: 4-3
+		{Cells: []int{14, 15}, Op: '/', Target: 2}, // R4C3 R4C4: 2/1
+	}
+	kk := NewKenKenPuzzle(4, cages)
+	solution := kk.solve()
+	if solution == nil {
+		t.Fatal("KenKenPuzzle.solve returned nil for a solvable puzzle")
+	}
+	if !kk.cagesConsistent(solution) {
+		t.Error("solution doesn't satisfy its own cages")
+	}
+	for r := 0; r < 4; r++ {
+		seen := uint64(0)
+		for c := 0; c < 4; c++ {
+			seen |= solution[r*4+c]
+		}
+		if seen != kk.b.AllDigits {
+			t.Errorf("row %d isn't a permutation of 1..4: %v", r, solution[r*4:r*4+4])
+		}
+	}
+}
+
+// TestStarBattleSolveAndVerify solves a tiny Star Battle puzzle (one star
+// per row/column/region) and checks the solution's quotas and adjacency.
+func TestStarBattleSolveAndVerify(t *testing.T) {
+	// 4x4 board, k=1, regions are the four 2x2 blocks (same shape as a
+	// blockHeight=2 Sudoku's blocks).
+	regions := []int{
+		0, 0, 1, 1,
+		0, 0, 1, 1,
+		2, 2, 3, 3,
+		2, 2, 3, 3,
+	}
+	sb := NewStarBattlePuzzle(4, 1, regions)
+	solution := sb.solve()
+	if solution == nil {
+		t.Fatal("StarBattlePuzzle.solve returned nil for a solvable puzzle")
+	}
+	if !sb.verify(solution) {
+		t.Error("solution failed its own verify")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+// TestHigherPropagateAgreesWithBaseline checks that turning on -P1/-P2
+// (naked/hidden subsets and X-wing) never changes the solution the csp
+// solver finds, only how it gets there.
+func TestHigherPropagateAgreesWithBaseline(t *testing.T) {
+	b := NewBoard(3, 3)
+	puzzle, err := b.parseGrid(easy9x9)
+	if err != nil {
+		t.Fatalf("parseGrid: %v", err)
+	}
+
+	savedLevel := propagationLevel
+	defer func() { propagationLevel = savedLevel }()
+
+	var solutions [3][]uint64
+	for level := 0; level <= 2; level++ {
+		propagationLevel = level
+		solutions[level] = NewCSPSolver(b).Solve(puzzle)
+		if solutions[level] == nil {
+			t.Fatalf("-P%d failed to solve a puzzle -P0 solves", level)
+		}
+		if !b.verify(solutions[level], puzzle) {
+			t.Fatalf("-P%d's solution failed verify", level)
+		}
+	}
+	for level := 1; level <= 2; level++ {
+		for s := range puzzle {
+			if solutions[0][s] != solutions[level][s] {
+				t.Fatalf("-P%d disagrees with -P0 at square %d: %v vs %v", level, s, solutions[level][s], solutions[0][s])
+			}
+		}
+	}
+}
+
+// TestHigherPropagateNoopAtLevelZero checks that -P0 (the default) leaves
+// grid untouched, since higherPropagate is meant to be a no-op extra pass
+// layered on top of fill's own arc/dual-consistency propagation.
+func TestHigherPropagateNoopAtLevelZero(t *testing.T) {
+	savedLevel := propagationLevel
+	defer func() { propagationLevel = savedLevel }()
+	propagationLevel = 0
+
+	b := NewBoard(3, 3)
+	grid := b.initialize(make([]uint64, b.N*b.N))
+	before := make([]uint64, len(grid))
+	copy(before, grid)
+
+	after := b.higherPropagate(grid)
+	for s := range before {
+		if after[s] != before[s] {
+			t.Fatalf("higherPropagate changed square %d at -P0: %v -> %v", s, before[s], after[s])
+		}
+	}
+}